@@ -3,15 +3,12 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -19,10 +16,12 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/fsnotify/fsnotify"
 	"github.com/krallistic/kazoo-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	plog "github.com/prometheus/common/promlog"
 	plogflag "github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
@@ -43,35 +42,77 @@ const (
 )
 
 var (
-	totalSizeMetric     *prometheus.Desc
-	numberOfReadsMetric *prometheus.Desc
-	maxReadMetric       *prometheus.Desc
-	rawDataLengthMetric *prometheus.Desc
+	totalSizeMetric      *prometheus.Desc
+	numberOfReadsMetric  *prometheus.Desc
+	maxReadMetric        *prometheus.Desc
+	rawDataLengthMetric  *prometheus.Desc
+	readDurationMetric   *prometheus.Desc
+	meanSignalPAMetric   *prometheus.Desc
+	stddevSignalPAMetric *prometheus.Desc
 )
 
+// readQuality holds the per-read nanopore-domain metrics derived from a
+// read's calibration attributes, keyed by read_id in readQualityMap.
+//
+// There is deliberately no translocation-speed field: the only inputs
+// available here are RawDataLength and SamplingRate, and any formula built
+// from just those two reduces to SamplingRate itself (durationSeconds is
+// RawDataLength/SamplingRate, so RawDataLength/durationSeconds is circular).
+// A real per-read translocation-speed estimate needs base-called event
+// boundaries, which this exporter doesn't have access to, so the metric
+// was pulled rather than ship a relabeled sampling_rate.
+type readQuality struct {
+	channel         string
+	runID           string
+	durationSeconds float64
+	meanPA          float64
+	stddevPA        float64
+}
+
 var filenameSizeMap = make(map[string]int64)
 
 var numberOfReadsMap = make(map[string]int)
 var rawDataLengthMap = make(map[string]int)
+var readQualityMap = make(map[string]*readQuality)
 var rawDataLengthTotal = 0
 var maxRawDataLengthMap = make(map[string]int)
 
+// fileContribution records exactly what one file added to the maps above,
+// so forgetFast5File can undo it (and a reparse can replace it) instead of
+// only dropping filenameSizeMap.
+type fileContribution struct {
+	readIDs          []string
+	readCount        map[string]int
+	rawDataLength    map[string]int
+	maxRawDataLength map[string]int
+}
+
+var fileContributionMap = make(map[string]*fileContribution)
+
 type Exporter struct {
 	client                  sarama.Client
 	topicFilter             *regexp.Regexp
 	groupFilter             *regexp.Regexp
-	mu                      sync.Mutex
+	mu                      sync.RWMutex
 	useZooKeeperLag         bool
 	zookeeperClient         *kazoo.Kazoo
 	nextMetadataRefresh     time.Time
 	metadataRefreshInterval time.Duration
 	offsetShowAll           bool
-	topicWorkers            int
+	parseWorkers            int
 	allowConcurrent         bool
 	sgMutex                 sync.Mutex
 	sgWaitCh                chan struct{}
 	sgChans                 []chan<- prometheus.Metric
 	consumerGroupFetchAll   bool
+	fast5DirPath            string
+	fast5RescanInterval     time.Duration
+	watcher                 *fsnotify.Watcher
+	producer                sarama.AsyncProducer
+	produceTopic            string
+	kafkaQueue              chan *readStatistics
+	parseJobs               chan parseJob
+	parseResults            chan *parseResult
 }
 
 type exporterOpts struct {
@@ -87,24 +128,23 @@ type exporterOpts struct {
 	tlsCAFile                string
 	tlsCertFile              string
 	tlsKeyFile               string
-	serverUseTLS             bool
-	serverMutualAuthEnabled  bool
-	serverTlsCAFile          string
-	serverTlsCertFile        string
-	serverTlsKeyFile         string
+	webConfigFile            string
 	tlsInsecureSkipTLSVerify bool
+	produceTopic             string
 	fast5Version             string
 	useZooKeeperLag          bool
 	uriZookeeper             []string
 	labels                   string
 	metadataRefreshInterval  string
+	ontFast5DirPath          string
+	fast5RescanInterval      string
 	serviceName              string
 	kerberosConfigPath       string
 	realm                    string
 	keyTabPath               string
 	kerberosAuthType         string
 	offsetShowAll            bool
-	topicWorkers             int
+	parseWorkers             int
 	allowConcurrent          bool
 	allowAutoTopicCreation   bool
 	verbosityLogLevel        int
@@ -131,6 +171,48 @@ func CanReadCertAndKey(certPath, keyPath string) (bool, error) {
 	return true, nil
 }
 
+// verifyWithReloadedCA returns a tls.Config.VerifyPeerCertificate callback
+// that re-reads caFile and rebuilds the trust pool on every handshake, so a
+// rotated broker CA is picked up without restarting the exporter. serverName
+// is checked against the leaf certificate the same way stdlib's own
+// verification would (skipped only if serverName is empty, matching
+// tls.Config's behavior when ServerName is unset).
+func verifyWithReloadedCA(caFile, serverName string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return errors.Wrap(err, "error reloading fast5 broker CA file")
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return errors.New("error parsing fast5 broker CA file")
+		}
+
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented by broker")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		intermediates := x509.NewCertPool()
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return errors.Wrap(err, "error parsing broker certificate")
+			}
+			certs[i] = cert
+			if i > 0 {
+				intermediates.AddCert(cert)
+			}
+		}
+
+		_, err = certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		})
+		return err
+	}
+}
+
 // If the file represented by path exists and
 // readable, returns true otherwise returns false.
 func canReadFile(path string) bool {
@@ -145,7 +227,7 @@ func canReadFile(path string) bool {
 }
 
 // NewExporter returns an initialized Exporter.
-func NewExporter(opts exporterOpts, topicFilter string, groupFilter string) (*Exporter, error) {
+func NewExporter(opts exporterOpts, topicFilter string, groupFilter string, fast5DirPath string) (*Exporter, error) {
 	var zookeeperClient *kazoo.Kazoo
 	config := sarama.NewConfig()
 	config.ClientID = clientID
@@ -206,16 +288,21 @@ func NewExporter(opts exporterOpts, topicFilter string, groupFilter string) (*Ex
 
 		config.Net.TLS.Config = &tls.Config{
 			ServerName:         opts.tlsServerName,
-			RootCAs:            x509.NewCertPool(),
 			InsecureSkipVerify: opts.tlsInsecureSkipTLSVerify,
 		}
 
-		if opts.tlsCAFile != "" {
-			if ca, err := ioutil.ReadFile(opts.tlsCAFile); err == nil {
-				config.Net.TLS.Config.RootCAs.AppendCertsFromPEM(ca)
-			} else {
+		if opts.tlsCAFile != "" && !opts.tlsInsecureSkipTLSVerify {
+			// crypto/tls has no per-handshake hook for RootCAs the way
+			// GetClientCertificate exists for client certs, so a pool built
+			// once here would never see a rotated broker CA. Instead,
+			// disable the built-in verification and replicate it in
+			// VerifyPeerCertificate against a pool reloaded from disk on
+			// every handshake.
+			if _, err := ioutil.ReadFile(opts.tlsCAFile); err != nil {
 				return nil, err
 			}
+			config.Net.TLS.Config.InsecureSkipVerify = true
+			config.Net.TLS.Config.VerifyPeerCertificate = verifyWithReloadedCA(opts.tlsCAFile, opts.tlsServerName)
 		}
 
 		canReadCertAndKey, err := CanReadCertAndKey(opts.tlsCertFile, opts.tlsKeyFile)
@@ -223,11 +310,16 @@ func NewExporter(opts exporterOpts, topicFilter string, groupFilter string) (*Ex
 			return nil, errors.Wrap(err, "error reading cert and key")
 		}
 		if canReadCertAndKey {
-			cert, err := tls.LoadX509KeyPair(opts.tlsCertFile, opts.tlsKeyFile)
-			if err == nil {
-				config.Net.TLS.Config.Certificates = []tls.Certificate{cert}
-			} else {
-				return nil, err
+			// Re-read the cert/key pair on every handshake instead of caching
+			// it once, so a rotated client certificate (e.g. after CA
+			// rotation) is picked up without restarting the exporter.
+			certFile, keyFile := opts.tlsCertFile, opts.tlsKeyFile
+			config.Net.TLS.Config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				if err != nil {
+					return nil, err
+				}
+				return &cert, nil
 			}
 		}
 	}
@@ -245,10 +337,19 @@ func NewExporter(opts exporterOpts, topicFilter string, groupFilter string) (*Ex
 		return nil, errors.Wrap(err, "Cannot parse metadata refresh interval")
 	}
 
+	rescanInterval, err := time.ParseDuration(opts.fast5RescanInterval)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot parse fast5 rescan interval")
+	}
+
 	config.Metadata.RefreshFrequency = interval
 
 	config.Metadata.AllowAutoTopicCreation = opts.allowAutoTopicCreation
 
+	if opts.produceTopic != "" {
+		enableKafkaProducer(config)
+	}
+
 	client, err := sarama.NewClient(opts.uri, config)
 
 	if err != nil {
@@ -266,12 +367,14 @@ func NewExporter(opts exporterOpts, topicFilter string, groupFilter string) (*Ex
 		nextMetadataRefresh:     time.Now(),
 		metadataRefreshInterval: interval,
 		offsetShowAll:           opts.offsetShowAll,
-		topicWorkers:            opts.topicWorkers,
+		parseWorkers:            opts.parseWorkers,
 		allowConcurrent:         opts.allowConcurrent,
 		sgMutex:                 sync.Mutex{},
 		sgWaitCh:                nil,
 		sgChans:                 []chan<- prometheus.Metric{},
 		consumerGroupFetchAll:   config.Version.IsAtLeast(sarama.V2_0_0_0),
+		fast5DirPath:            fast5DirPath,
+		fast5RescanInterval:     rescanInterval,
 	}, nil
 }
 
@@ -294,6 +397,9 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- numberOfReadsMetric
 	ch <- maxReadMetric
 	ch <- rawDataLengthMetric
+	ch <- readDurationMetric
+	ch <- meanSignalPAMetric
+	ch <- stddevSignalPAMetric
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
@@ -345,38 +451,12 @@ func (e *Exporter) collectChans(quit chan struct{}) {
 	e.sgMutex.Unlock()
 }
 
-type statisticsData struct {
-	FileSize      string `json:'fileSize'`
-	RawDataLength string `json:'rawDataLength'`
-	ChannelNumber string `json:'channelNumber'`
-	Digitisation  string `json:'digitisation'`
-	Offset        string `json:'offset'`
-	Range         string `json:'range'`
-	SamplingRate  string `json:'samplingRate'`
-}
-
+// collect emits the gauges aggregated by the background fsnotify watcher
+// (see fast5_watcher.go). It no longer walks the fast5 directory itself,
+// so a Prometheus scrape is just a snapshot read under e.mu.
 func (e *Exporter) collect(ch chan<- prometheus.Metric) {
-	filepath.Walk("/tmp/fast5", func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".fast5" {
-			if _, ok := filenameSizeMap[path]; ok {
-				if filenameSizeMap[path] == info.Size() {
-					//continue
-				} else {
-					filenameSizeMap[path] = info.Size()
-					e.run(path)
-				}
-
-			} else {
-				filenameSizeMap[path] = info.Size()
-				e.run(path)
-			}
-		}
-
-		if err != nil {
-			fmt.Println("ERROR:", err)
-		}
-		return nil
-	})
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
 	var totalSize = 0
 
@@ -405,41 +485,120 @@ func (e *Exporter) collect(ch chan<- prometheus.Metric) {
 			rawDataLengthMetric, prometheus.GaugeValue, float64(element), "address", "name", key,
 		)
 	}
+
+	for readID, quality := range readQualityMap {
+		ch <- prometheus.MustNewConstMetric(
+			readDurationMetric, prometheus.GaugeValue, quality.durationSeconds, "address", "name", quality.channel, readID, quality.runID,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			meanSignalPAMetric, prometheus.GaugeValue, quality.meanPA, "address", "name", quality.channel, readID, quality.runID,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			stddevSignalPAMetric, prometheus.GaugeValue, quality.stddevPA, "address", "name", quality.channel, readID, quality.runID,
+		)
+	}
 }
 
-func (e *Exporter) run(path string) {
-	var statistics []*statisticsData
-	result, err := exec.Command("python3", "python/parse_fast5_file.py", "--path", path).Output()
-	if err != nil {
-		log.Fatal(err)
+// applyStatistics merges path's parsed read statistics into the
+// package-level aggregate maps under e.mu, then publishes each read to
+// Kafka if a producer is configured. It's the only place reads are added
+// to these maps (forgetFast5File removes them, also under e.mu); see
+// fast5_worker_pool.go for who calls it.
+//
+// path's previous contribution (if any, e.g. a file that was rewritten and
+// reparsed) is undone first, so a file's reads are never double-counted and
+// forgetFast5File can later remove exactly what this call added.
+func (e *Exporter) applyStatistics(path string, statistics []*readStatistics) {
+	e.mu.Lock()
+
+	affectedChannels := make(map[string]struct{})
+	if old, ok := fileContributionMap[path]; ok {
+		removeContribution(old, affectedChannels)
 	}
-	err = json.Unmarshal([]byte(result), &statistics)
-	if err != nil {
-		log.Fatal(err)
+
+	contribution := &fileContribution{
+		readCount:        make(map[string]int),
+		rawDataLength:    make(map[string]int),
+		maxRawDataLength: make(map[string]int),
 	}
+
 	for _, statistic := range statistics {
-		RawDataLength, _ := strconv.Atoi(statistic.RawDataLength)
-		NumberOfReads, _ := 1, 1
-		rawDataLengthTotal += RawDataLength
-		if _, ok := numberOfReadsMap[statistic.ChannelNumber]; ok {
-			numberOfReadsMap[statistic.ChannelNumber] += NumberOfReads
-		} else {
-			numberOfReadsMap[statistic.ChannelNumber] = NumberOfReads
+		channel := strconv.Itoa(statistic.ChannelNumber)
+		RawDataLength := statistic.RawDataLength
+
+		contribution.readIDs = append(contribution.readIDs, statistic.ReadID)
+		contribution.readCount[channel]++
+		contribution.rawDataLength[channel] += RawDataLength
+		if RawDataLength > contribution.maxRawDataLength[channel] {
+			contribution.maxRawDataLength[channel] = RawDataLength
 		}
-		if _, ok := rawDataLengthMap[statistic.ChannelNumber]; ok {
-			rawDataLengthMap[statistic.ChannelNumber] += RawDataLength
-		} else {
-			rawDataLengthMap[statistic.ChannelNumber] = RawDataLength
+		affectedChannels[channel] = struct{}{}
+
+		rawDataLengthTotal += RawDataLength
+		numberOfReadsMap[channel]++
+		rawDataLengthMap[channel] += RawDataLength
+
+		readQualityMap[statistic.ReadID] = &readQuality{
+			channel:         channel,
+			runID:           statistic.RunID,
+			durationSeconds: statistic.DurationSeconds,
+			meanPA:          statistic.MeanPA,
+			stddevPA:        statistic.StddevPA,
 		}
+	}
+	fileContributionMap[path] = contribution
 
-		if _, ok := maxRawDataLengthMap[statistic.ChannelNumber]; ok {
-			if (RawDataLength) > maxRawDataLengthMap[statistic.ChannelNumber] {
-				maxRawDataLengthMap[statistic.ChannelNumber] = RawDataLength
-			}
-		} else {
-			maxRawDataLengthMap[statistic.ChannelNumber] = RawDataLength
+	for channel := range affectedChannels {
+		recomputeMaxRawDataLength(channel)
+	}
+
+	e.mu.Unlock()
+
+	for _, statistic := range statistics {
+		e.publishRead(statistic)
+	}
+}
+
+// removeContribution undoes everything contribution added to the aggregate
+// maps (except maxRawDataLengthMap, which the caller must recompute via
+// recomputeMaxRawDataLength for every channel in affectedChannels once the
+// contribution is no longer in fileContributionMap). Callers must hold e.mu.
+func removeContribution(contribution *fileContribution, affectedChannels map[string]struct{}) {
+	for channel, count := range contribution.readCount {
+		numberOfReadsMap[channel] -= count
+		if numberOfReadsMap[channel] <= 0 {
+			delete(numberOfReadsMap, channel)
 		}
+		affectedChannels[channel] = struct{}{}
+	}
+	for channel, length := range contribution.rawDataLength {
+		rawDataLengthMap[channel] -= length
+		rawDataLengthTotal -= length
+		if rawDataLengthMap[channel] <= 0 {
+			delete(rawDataLengthMap, channel)
+		}
+	}
+	for _, readID := range contribution.readIDs {
+		delete(readQualityMap, readID)
+	}
+}
 
+// recomputeMaxRawDataLength rebuilds maxRawDataLengthMap[channel] from
+// every file still in fileContributionMap, since a removed file may have
+// held the current maximum. Callers must hold e.mu.
+func recomputeMaxRawDataLength(channel string) {
+	max := 0
+	found := false
+	for _, contribution := range fileContributionMap {
+		if length, ok := contribution.maxRawDataLength[channel]; ok && (!found || length > max) {
+			max = length
+			found = true
+		}
+	}
+	if found {
+		maxRawDataLengthMap[channel] = max
+	} else {
+		delete(maxRawDataLengthMap, channel)
 	}
 }
 
@@ -507,11 +666,7 @@ func main() {
 	toFlagStringVar("tls.ca-file", "The optional certificate authority file for fast5 TLS client authentication.", "", &opts.tlsCAFile)
 	toFlagStringVar("tls.cert-file", "The optional certificate file for fast5 client authentication.", "", &opts.tlsCertFile)
 	toFlagStringVar("tls.key-file", "The optional key file for fast5 client authentication.", "", &opts.tlsKeyFile)
-	toFlagBoolVar("server.tls.enabled", "Enable TLS for web server, default is false.", false, "false", &opts.serverUseTLS)
-	toFlagBoolVar("server.tls.mutual-auth-enabled", "Enable TLS client mutual authentication, default is false.", false, "false", &opts.serverMutualAuthEnabled)
-	toFlagStringVar("server.tls.ca-file", "The certificate authority file for the web server.", "", &opts.serverTlsCAFile)
-	toFlagStringVar("server.tls.cert-file", "The certificate file for the web server.", "", &opts.serverTlsCertFile)
-	toFlagStringVar("server.tls.key-file", "The key file for the web server.", "", &opts.serverTlsKeyFile)
+	toFlagStringVar("web.config.file", "[EXPERIMENTAL] Path to configuration file that can enable TLS or authentication. See: https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md", "", &opts.webConfigFile)
 	toFlagBoolVar("tls.insecure-skip-tls-verify", "If true, the server's certificate will not be checked for validity. This will make your HTTPS connections insecure. Default is false", false, "false", &opts.tlsInsecureSkipTLSVerify)
 	toFlagStringVar("fast5.version", "Fast5 version", sarama.V2_0_0_0.String(), &opts.fast5Version)
 	toFlagBoolVar("use.consumelag.zookeeper", "if you need to use a group from zookeeper, default is false", false, "false", &opts.useZooKeeperLag)
@@ -520,9 +675,11 @@ func main() {
 	toFlagStringVar("refresh.metadata", "Metadata refresh interval", "30s", &opts.metadataRefreshInterval)
 	toFlagBoolVar("offset.show-all", "Whether show the offset/lag for all consumer group, otherwise, only show connected consumer groups, default is true", true, "true", &opts.offsetShowAll)
 	toFlagBoolVar("concurrent.enable", "If true, all scrapes will trigger fast5 operations otherwise, they will share results. WARN: This should be disabled on large clusters. Default is false", false, "false", &opts.allowConcurrent)
-	toFlagIntVar("topic.workers", "Number of topic workers", 100, "100", &opts.topicWorkers)
+	toFlagIntVar("fast5.parse-workers", "Number of worker goroutines parsing fast5 files concurrently", 100, "100", &opts.parseWorkers)
 	toFlagBoolVar("fast5.allow-auto-topic-creation", "If true, the broker may auto-create topics that we requested which do not already exist, default is false.", false, "false", &opts.allowAutoTopicCreation)
 	toFlagIntVar("verbosity", "Verbosity log level", 0, "0", &opts.verbosityLogLevel)
+	toFlagStringVar("fast5.rescan-interval", "Fallback interval to rescan the fast5 directory on filesystems (e.g. NFS/SMB) where inotify events are unreliable. 0 disables the fallback.", "0", &opts.fast5RescanInterval)
+	toFlagStringVar("fast5.produce-topic", "If set, publish a JSON event for every parsed read to this Kafka topic.", "", &opts.produceTopic)
 
 	plConfig := plog.Config{}
 	plogflag.AddFlags(kingpin.CommandLine, &plConfig)
@@ -541,7 +698,7 @@ func main() {
 		}
 	}
 
-	setup(*ontFast5DirPath, *listenAddress, *metricsPath, *topicFilter, *groupFilter, *logSarama, opts, labels)
+	setup(*ontFast5DirPath, *listenAddress, *metricsPath, *topicFilter, *groupFilter, *logSarama, opts, labels, plConfig)
 }
 
 func setup(
@@ -553,6 +710,7 @@ func setup(
 	logSarama bool,
 	opts exporterOpts,
 	labels map[string]string,
+	plConfig plog.Config,
 ) {
 	klog.InitFlags(flag.CommandLine)
 	if err := flag.Set("logtostderr", "true"); err != nil {
@@ -587,18 +745,42 @@ func setup(
 		"Stats",
 		[]string{"address", "name", "channel"}, labels,
 	)
+	readDurationMetric = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "read_duration", "seconds"),
+		"Read duration in seconds, derived from signal length and sampling rate.",
+		[]string{"address", "name", "channel", "read_id", "run_id"}, labels,
+	)
+	meanSignalPAMetric = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "signal_pA", "mean"),
+		"Mean calibrated signal in picoamperes for the read.",
+		[]string{"address", "name", "channel", "read_id", "run_id"}, labels,
+	)
+	stddevSignalPAMetric = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "signal_pA", "stddev"),
+		"Standard deviation of the calibrated signal in picoamperes for the read.",
+		[]string{"address", "name", "channel", "read_id", "run_id"}, labels,
+	)
 
 	if logSarama {
 		sarama.Logger = log.New(os.Stdout, "[sarama] ", log.LstdFlags)
 	}
 
-	exporter, err := NewExporter(opts, topicFilter, groupFilter)
+	exporter, err := NewExporter(opts, topicFilter, groupFilter, ontFast5DirPath)
 	if err != nil {
 		//			klog.Fatalln(err)
 	}
 	//		defer exporter.client.Close()
 	prometheus.MustRegister(exporter)
 
+	if opts.produceTopic != "" {
+		if err := exporter.startKafkaProducer(opts.produceTopic); err != nil {
+			klog.Errorf("Error starting fast5 kafka producer: %v", err)
+		}
+	}
+
+	exporter.startParsePipeline()
+	go exporter.watchFast5Dir()
+
 	http.Handle(metricsPath, promhttp.Handler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte(`<html>
@@ -619,51 +801,11 @@ func setup(
 		}
 	})
 
-	if opts.serverUseTLS {
-		klog.V(INFO).Infoln("Listening on HTTPS", listenAddress)
-
-		_, err := CanReadCertAndKey(opts.serverTlsCertFile, opts.serverTlsKeyFile)
-		if err != nil {
-			klog.Error("error reading server cert and key")
-		}
-
-		clientAuthType := tls.NoClientCert
-		if opts.serverMutualAuthEnabled {
-			clientAuthType = tls.RequireAndVerifyClientCert
-		}
-
-		certPool := x509.NewCertPool()
-		if opts.serverTlsCAFile != "" {
-			if caCert, err := ioutil.ReadFile(opts.serverTlsCAFile); err == nil {
-				certPool.AppendCertsFromPEM(caCert)
-			} else {
-				klog.Error("error reading server ca")
-			}
-		}
-
-		tlsConfig := &tls.Config{
-			ClientCAs:                certPool,
-			ClientAuth:               clientAuthType,
-			MinVersion:               tls.VersionTLS12,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
-			},
-		}
-		server := &http.Server{
-			Addr:      listenAddress,
-			TLSConfig: tlsConfig,
-		}
-		klog.Fatal(server.ListenAndServeTLS(opts.serverTlsCertFile, opts.serverTlsKeyFile))
-	} else {
-		klog.V(INFO).Infoln("Listening on HTTP", listenAddress)
-		klog.Fatal(http.ListenAndServe(listenAddress, nil))
+	server := &http.Server{Addr: listenAddress}
+	webFlagConfig := &web.FlagConfig{
+		WebListenAddresses: &[]string{listenAddress},
+		WebConfigFile:      &opts.webConfigFile,
 	}
+	klog.V(INFO).Infoln("Listening on", listenAddress)
+	klog.Fatal(web.ListenAndServe(server, webFlagConfig, plog.New(&plConfig)))
 }
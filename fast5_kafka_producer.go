@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	kafkaMessagesSentTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_sent_total",
+		Help:      "Total number of FAST5 read events successfully published to Kafka.",
+	})
+	kafkaErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_errors_total",
+		Help:      "Total number of errors publishing FAST5 read events to Kafka.",
+	})
+	kafkaMessagesInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_in_flight",
+		Help:      "Number of FAST5 read events handed to the async producer but not yet acknowledged.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(kafkaMessagesSentTotal, kafkaErrorsTotal, kafkaMessagesInFlight)
+}
+
+// kafkaQueueSize bounds e.kafkaQueue, the hand-off between applyStatistics
+// (the single aggregator goroutine) and kafkaPublishLoop (the goroutine that
+// actually blocks on producer.Input()), so a slow or unreachable broker can
+// never stall the aggregator.
+const kafkaQueueSize = 4096
+
+// fast5ReadEvent is the JSON payload published to --fast5.produce-topic for
+// every read parsed out of a FAST5 file.
+type fast5ReadEvent struct {
+	ReadID          string  `json:"read_id"`
+	RunID           string  `json:"run_id"`
+	ChannelNumber   int     `json:"channel_number"`
+	RawDataLength   int     `json:"raw_data_length"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	MeanPA          float64 `json:"mean_pa"`
+}
+
+// enableKafkaProducer configures config for idempotent, at-least-once
+// production (acks=all). It must be called before config is passed to
+// sarama.NewClient; the producer itself is created later by
+// startKafkaProducer from the connected client.
+func enableKafkaProducer(config *sarama.Config) {
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Net.MaxOpenRequests = 1
+}
+
+// startKafkaProducer creates an AsyncProducer from the exporter's client and
+// starts a goroutine draining its Successes/Errors channels into the
+// fast5_kafka_* metrics. Call once, after NewExporter, when
+// --fast5.produce-topic is set.
+func (e *Exporter) startKafkaProducer(topic string) error {
+	producer, err := sarama.NewAsyncProducerFromClient(e.client)
+	if err != nil {
+		return errors.Wrap(err, "error creating fast5 kafka producer")
+	}
+	e.producer = producer
+	e.produceTopic = topic
+	e.kafkaQueue = make(chan *readStatistics, kafkaQueueSize)
+	go e.kafkaPublishLoop()
+
+	go func() {
+		for {
+			select {
+			case <-producer.Successes():
+				kafkaMessagesSentTotal.Inc()
+				kafkaMessagesInFlight.Dec()
+			case err, ok := <-producer.Errors():
+				if !ok {
+					return
+				}
+				klog.Errorf("Error publishing fast5 read event: %v", err)
+				kafkaErrorsTotal.Inc()
+				kafkaMessagesInFlight.Dec()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// publishRead hands off a read's statistics to kafkaPublishLoop without
+// blocking. It's called from applyStatistics on the single aggregator
+// goroutine (see fast5_worker_pool.go), so it must never block on Kafka
+// I/O: a slow or unreachable broker would otherwise back up parseResults,
+// then parseJobs, then the fsnotify watcher's event loop. If the queue is
+// ever full the event is dropped and counted rather than blocking.
+func (e *Exporter) publishRead(statistic *readStatistics) {
+	if e.producer == nil {
+		return
+	}
+
+	select {
+	case e.kafkaQueue <- statistic:
+	default:
+		klog.Errorf("Dropping fast5 read event for read %s: kafka publish queue full", statistic.ReadID)
+		kafkaErrorsTotal.Inc()
+	}
+}
+
+// kafkaPublishLoop drains e.kafkaQueue and is the only goroutine that
+// blocks on producer.Input(), keeping that backpressure off the aggregator.
+func (e *Exporter) kafkaPublishLoop() {
+	for statistic := range e.kafkaQueue {
+		event := fast5ReadEvent{
+			ReadID:          statistic.ReadID,
+			RunID:           statistic.RunID,
+			ChannelNumber:   statistic.ChannelNumber,
+			RawDataLength:   statistic.RawDataLength,
+			DurationSeconds: statistic.DurationSeconds,
+			MeanPA:          statistic.MeanPA,
+		}
+
+		value, err := json.Marshal(event)
+		if err != nil {
+			klog.Errorf("Error marshaling fast5 read event: %v", err)
+			continue
+		}
+
+		kafkaMessagesInFlight.Inc()
+		// Keying on the channel number (hashed into a partition by sarama's
+		// default partitioner) keeps every read from the same pore on one
+		// partition, so a downstream consumer sees them in order.
+		e.producer.Input() <- &sarama.ProducerMessage{
+			Topic: e.produceTopic,
+			Key:   sarama.StringEncoder(strconv.Itoa(statistic.ChannelNumber)),
+			Value: sarama.ByteEncoder(value),
+		}
+	}
+}
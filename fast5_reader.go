@@ -0,0 +1,233 @@
+package main
+
+/*
+#cgo LDFLAGS: -lhdf5
+#include <hdf5.h>
+#include <stdlib.h>
+
+// read_double_attr reads a scalar double attribute from obj into out.
+// Returns 0 on success, negative on failure.
+static herr_t read_double_attr(hid_t obj, const char *name, double *out) {
+	hid_t attr = H5Aopen(obj, name, H5P_DEFAULT);
+	if (attr < 0) {
+		return -1;
+	}
+	herr_t status = H5Aread(attr, H5T_NATIVE_DOUBLE, out);
+	H5Aclose(attr);
+	return status;
+}
+
+// read_string_attr reads a string attribute from obj into out
+// (caller-allocated, outLen bytes) as a fixed-length, null-terminated
+// string of outLen bytes. Returns 0 on success, negative on failure.
+static herr_t read_string_attr(hid_t obj, const char *name, char *out, size_t outLen) {
+	hid_t attr = H5Aopen(obj, name, H5P_DEFAULT);
+	if (attr < 0) {
+		return -1;
+	}
+	hid_t type = H5Tcopy(H5T_C_S1);
+	H5Tset_size(type, outLen);
+	herr_t status = H5Aread(attr, type, out);
+	H5Tclose(type);
+	H5Aclose(attr);
+	return status;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// readStatistics holds the per-read metrics extracted directly from a
+// FAST5/HDF5 file's Raw/Read_* group, replacing the fields previously
+// produced by python/parse_fast5_file.py.
+type readStatistics struct {
+	ReadID          string
+	RunID           string
+	ChannelNumber   int
+	Digitisation    float64
+	Offset          float64
+	Range           float64
+	SamplingRate    float64
+	RawDataLength   int
+	DurationSeconds float64
+	MeanPA          float64
+	StddevPA        float64
+}
+
+// parseFast5FileFunc is the entry point fast5_worker_pool.go calls into.
+// It's a package-level var rather than calling parseFast5File directly so
+// fast5_worker_pool_test.go can swap in a stub and benchmark the pipeline's
+// fan-out without needing real HDF5 files on disk.
+var parseFast5FileFunc = parseFast5File
+
+// parseFast5File opens path with libhdf5, walks its Raw/Read_* groups and
+// returns the calibration/signal metrics for every read found. The file is
+// opened and closed once per call, regardless of how many reads it contains.
+func parseFast5File(path string) ([]*readStatistics, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	file := C.H5Fopen(cPath, C.H5F_ACC_RDONLY, C.H5P_DEFAULT)
+	if file < 0 {
+		return nil, fmt.Errorf("could not open %s as HDF5", path)
+	}
+	defer C.H5Fclose(file)
+
+	cRaw := C.CString("Raw")
+	defer C.free(unsafe.Pointer(cRaw))
+	rawGroup := C.H5Gopen2(file, cRaw, C.H5P_DEFAULT)
+	if rawGroup < 0 {
+		return nil, errors.Errorf("no Raw group in %s", path)
+	}
+	defer C.H5Gclose(rawGroup)
+
+	runID := readRunID(file, path)
+
+	var numObjects C.hsize_t
+	if C.H5Gget_num_objs(rawGroup, &numObjects) < 0 {
+		return nil, errors.Errorf("could not enumerate Raw group in %s", path)
+	}
+
+	statistics := make([]*readStatistics, 0, int(numObjects))
+	for i := C.hsize_t(0); i < numObjects; i++ {
+		nameBuf := make([]byte, 256)
+		C.H5Gget_objname_by_idx(rawGroup, i, (*C.char)(unsafe.Pointer(&nameBuf[0])), C.size_t(len(nameBuf)))
+		readName := C.GoString((*C.char)(unsafe.Pointer(&nameBuf[0])))
+
+		stat, err := parseRead(rawGroup, readName)
+		if err != nil {
+			klog.V(DEBUG).Infof("skipping %s in %s: %v", readName, path, err)
+			continue
+		}
+		stat.RunID = runID
+		statistics = append(statistics, stat)
+	}
+
+	return statistics, nil
+}
+
+// readRunID looks up the sequencing run identifier stored under
+// UniqueGlobalKey/tracking_id. It is recorded once per file rather than per
+// read, so a missing attribute just yields an empty label instead of an
+// error.
+func readRunID(file C.hid_t, path string) string {
+	cTrackingID := C.CString("UniqueGlobalKey/tracking_id")
+	defer C.free(unsafe.Pointer(cTrackingID))
+	trackingID := C.H5Gopen2(file, cTrackingID, C.H5P_DEFAULT)
+	if trackingID < 0 {
+		klog.V(DEBUG).Infof("no tracking_id group in %s", path)
+		return ""
+	}
+	defer C.H5Gclose(trackingID)
+
+	buf := make([]byte, 64)
+	cName := C.CString("run_id")
+	defer C.free(unsafe.Pointer(cName))
+	if C.read_string_attr(trackingID, cName, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))) < 0 {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
+}
+
+// parseRead extracts the calibration attributes and Signal dataset length
+// for a single Raw/Read_* group.
+func parseRead(rawGroup C.hid_t, readName string) (*readStatistics, error) {
+	cReadName := C.CString(readName)
+	defer C.free(unsafe.Pointer(cReadName))
+
+	readGroup := C.H5Gopen2(rawGroup, cReadName, C.H5P_DEFAULT)
+	if readGroup < 0 {
+		return nil, errors.Errorf("could not open read group %s", readName)
+	}
+	defer C.H5Gclose(readGroup)
+
+	var channelNumber, digitisation, offset, sampleRange, samplingRate C.double
+	attrs := []struct {
+		name string
+		out  *C.double
+	}{
+		{"channel_number", &channelNumber},
+		{"digitisation", &digitisation},
+		{"offset", &offset},
+		{"range", &sampleRange},
+		{"sampling_rate", &samplingRate},
+	}
+	for _, attr := range attrs {
+		cName := C.CString(attr.name)
+		status := C.read_double_attr(readGroup, cName, attr.out)
+		C.free(unsafe.Pointer(cName))
+		if status < 0 {
+			return nil, errors.Errorf("missing attribute %s", attr.name)
+		}
+	}
+	if samplingRate == 0 {
+		return nil, errors.New("sampling_rate is zero")
+	}
+
+	cSignal := C.CString("Signal")
+	defer C.free(unsafe.Pointer(cSignal))
+	signal := C.H5Dopen2(readGroup, cSignal, C.H5P_DEFAULT)
+	if signal < 0 {
+		return nil, errors.New("missing Signal dataset")
+	}
+	defer C.H5Dclose(signal)
+
+	space := C.H5Dget_space(signal)
+	defer C.H5Sclose(space)
+	var dims [1]C.hsize_t
+	C.H5Sget_simple_extent_dims(space, &dims[0], nil)
+	rawDataLength := int(dims[0])
+
+	raw := make([]C.short, rawDataLength)
+	if rawDataLength > 0 {
+		C.H5Dread(signal, C.H5T_NATIVE_SHORT, C.H5S_ALL, C.H5S_ALL, C.H5P_DEFAULT, unsafe.Pointer(&raw[0]))
+	}
+
+	meanPA, stddevPA := calibratedSignalStats(raw, float64(offset), float64(sampleRange), float64(digitisation))
+	durationSeconds := float64(rawDataLength) / float64(samplingRate)
+
+	return &readStatistics{
+		ReadID:          readName,
+		ChannelNumber:   int(channelNumber),
+		Digitisation:    float64(digitisation),
+		Offset:          float64(offset),
+		Range:           float64(sampleRange),
+		SamplingRate:    float64(samplingRate),
+		RawDataLength:   rawDataLength,
+		DurationSeconds: durationSeconds,
+		MeanPA:          meanPA,
+		StddevPA:        stddevPA,
+	}, nil
+}
+
+// calibratedSignalStats converts raw ADC samples to picoamperes using the
+// standard ONT calibration (pA = (raw + offset) * range / digitisation) and
+// returns their mean and population standard deviation.
+func calibratedSignalStats(raw []C.short, offset, sampleRange, digitisation float64) (mean, stddev float64) {
+	if len(raw) == 0 || digitisation == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, sample := range raw {
+		sum += (float64(sample) + offset) * sampleRange / digitisation
+	}
+	mean = sum / float64(len(raw))
+
+	var variance float64
+	for _, sample := range raw {
+		pA := (float64(sample) + offset) * sampleRange / digitisation
+		variance += (pA - mean) * (pA - mean)
+	}
+	variance /= float64(len(raw))
+	stddev = math.Sqrt(variance)
+
+	return mean, stddev
+}
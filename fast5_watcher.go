@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+// watchFast5Dir runs a background fsnotify watcher rooted at e.fast5DirPath.
+// New subdirectories are watched as they're created, each new/modified
+// .fast5 file is parsed exactly once into the package-level aggregate maps,
+// and state for removed files is dropped. It runs until the watcher cannot
+// be created or its channels are closed; start it with
+// `go exporter.watchFast5Dir()`.
+func (e *Exporter) watchFast5Dir() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("Error creating fsnotify watcher: %v", err)
+		return
+	}
+	e.watcher = watcher
+	defer watcher.Close()
+
+	if err := addWatchRecursive(watcher, e.fast5DirPath); err != nil {
+		klog.Errorf("Error watching %s: %v", e.fast5DirPath, err)
+	}
+	e.scanExisting(e.fast5DirPath)
+
+	var rescan <-chan time.Time
+	if e.fast5RescanInterval > 0 {
+		ticker := time.NewTicker(e.fast5RescanInterval)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			e.handleFast5Event(event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Errorf("fsnotify error: %v", err)
+		case <-rescan:
+			klog.V(DEBUG).Infoln("Rescanning", e.fast5DirPath, "(rescan-interval fallback)")
+			e.scanExisting(e.fast5DirPath)
+		}
+	}
+}
+
+// addWatchRecursive adds an inotify watch for root and every directory
+// beneath it.
+func addWatchRecursive(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || !info.IsDir() {
+			return nil
+		}
+		if watchErr := watcher.Add(path); watchErr != nil {
+			klog.Errorf("Error watching directory %s: %v", path, watchErr)
+		}
+		return nil
+	})
+}
+
+// scanExisting parses every .fast5 file under root whose size hasn't been
+// seen before. It covers files that were already present at startup and
+// backs up the fsnotify stream on unreliable (NFS/SMB) mounts.
+func (e *Exporter) scanExisting(root string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || info.IsDir() || filepath.Ext(path) != ".fast5" {
+			return nil
+		}
+		e.parseIfChanged(path, info.Size())
+		return nil
+	})
+}
+
+// handleFast5Event reacts to a single fsnotify event: new directories get
+// watched, removed/renamed files drop their aggregate state, and
+// created/modified .fast5 files are (re)parsed.
+func (e *Exporter) handleFast5Event(event fsnotify.Event) {
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := addWatchRecursive(e.watcher, event.Name); err != nil {
+				klog.Errorf("Error watching new directory %s: %v", event.Name, err)
+			}
+			return
+		}
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		e.forgetFast5File(event.Name)
+		return
+	}
+
+	if filepath.Ext(event.Name) != ".fast5" || event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	e.parseIfChanged(event.Name, info.Size())
+}
+
+// parseIfChanged enqueues path onto the parse worker pool (see
+// fast5_worker_pool.go) unless its size is unchanged since the last parse.
+func (e *Exporter) parseIfChanged(path string, size int64) {
+	e.mu.RLock()
+	known, ok := filenameSizeMap[path]
+	e.mu.RUnlock()
+	if ok && known == size {
+		return
+	}
+
+	e.parseJobs <- parseJob{path: path, size: size}
+}
+
+// forgetFast5File drops all aggregate state recorded for a file that has
+// been removed or renamed away: its entry in filenameSizeMap, its reads in
+// readQualityMap, its share of numberOfReadsMap/rawDataLengthMap, and (via
+// recomputeMaxRawDataLength) its contribution to maxRawDataLengthMap.
+func (e *Exporter) forgetFast5File(path string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(filenameSizeMap, path)
+
+	contribution, ok := fileContributionMap[path]
+	if !ok {
+		return
+	}
+	delete(fileContributionMap, path)
+
+	affectedChannels := make(map[string]struct{})
+	removeContribution(contribution, affectedChannels)
+	for channel := range affectedChannels {
+		recomputeMaxRawDataLength(channel)
+	}
+}
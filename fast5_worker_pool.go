@@ -0,0 +1,65 @@
+package main
+
+import (
+	"k8s.io/klog/v2"
+)
+
+// parseJob is a single fast5 file discovered by the watcher (or the
+// rescan fallback) that needs to be parsed.
+type parseJob struct {
+	path string
+	size int64
+}
+
+// parseResult is what a worker hands back to the aggregator once a file
+// has been parsed.
+type parseResult struct {
+	path       string
+	statistics []*readStatistics
+}
+
+// startParsePipeline starts e.parseWorkers goroutines pulling from
+// e.parseJobs and a single aggregator goroutine that applies their results
+// to the shared maps. Callers (fast5_watcher.go) push work onto
+// e.parseJobs instead of parsing files inline, so a directory full of
+// changed files no longer serializes behind one goroutine.
+func (e *Exporter) startParsePipeline() {
+	e.parseJobs = make(chan parseJob, 1024)
+	e.parseResults = make(chan *parseResult, 1024)
+
+	workers := e.parseWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go e.parseWorker()
+	}
+	go e.aggregateParseResults()
+}
+
+// parseWorker parses fast5 files off e.parseJobs until the channel is
+// closed.
+func (e *Exporter) parseWorker() {
+	for job := range e.parseJobs {
+		statistics, err := parseFast5FileFunc(job.path)
+		if err != nil {
+			klog.Errorf("Error parsing fast5 file %s: %v", job.path, err)
+			continue
+		}
+
+		e.mu.Lock()
+		filenameSizeMap[job.path] = job.size
+		e.mu.Unlock()
+
+		e.parseResults <- &parseResult{path: job.path, statistics: statistics}
+	}
+}
+
+// aggregateParseResults is the single writer that merges every worker's
+// results into the shared maps, so a concurrent Prometheus Collect always
+// sees a consistent snapshot.
+func (e *Exporter) aggregateParseResults() {
+	for result := range e.parseResults {
+		e.applyStatistics(result.path, result.statistics)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkParsePipelineWorkers feeds a directory-sized batch of fast5 files
+// through startParsePipeline at increasing worker counts. parseFast5FileFunc
+// is stubbed out with a fixed per-file delay standing in for libhdf5 I/O, so
+// the benchmark measures the worker pool's fan-out rather than real HDF5
+// parsing (which needs libhdf5 and cgo, unavailable in a plain `go test`
+// environment) -- wall time should fall roughly in proportion to workers.
+func BenchmarkParsePipelineWorkers(b *testing.B) {
+	const fileCount = 1000
+	const perFileCost = time.Millisecond
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		workers := workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			original := parseFast5FileFunc
+			defer func() { parseFast5FileFunc = original }()
+
+			var wg sync.WaitGroup
+			parseFast5FileFunc = func(path string) ([]*readStatistics, error) {
+				time.Sleep(perFileCost)
+				wg.Done()
+				return []*readStatistics{{ReadID: path, ChannelNumber: 1, RawDataLength: 1000}}, nil
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e := &Exporter{parseWorkers: workers}
+				e.startParsePipeline()
+
+				wg.Add(fileCount)
+				for j := 0; j < fileCount; j++ {
+					e.parseJobs <- parseJob{path: fmt.Sprintf("read_%d.fast5", j), size: int64(j)}
+				}
+				wg.Wait()
+				close(e.parseJobs)
+			}
+		})
+	}
+}